@@ -3,138 +3,497 @@
 package pubsub
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 )
 
-type subscriberCloseFunc func()
-
 var (
-	// ErrClosed is returned when a publish is attempted on a closed publisher.
+	// ErrClosed is returned when a publish is attempted on a closed publisher,
+	// and is also the Err() reason for subscriptions still open when the
+	// publisher closes.
 	ErrClosed = errors.New("pubsub: closed")
+	// ErrDisconnected is the Err() reason for a subscription torn down by the
+	// Disconnect SlowConsumerPolicy.
+	ErrDisconnected = errors.New("pubsub: disconnected by slow consumer policy")
+)
+
+// SlowConsumerPolicy controls what happens when a subscriber falls behind the
+// publisher by more than its ChannelCapacity, or its oldest undelivered event
+// has been waiting longer than the publisher's PublishTimeout. Both checks are
+// only made opportunistically, inside Publish/PublishTopic, on every current
+// subscriber whatever the event's topic; there is no background timer, so a
+// subscriber that falls behind will not be caught by PublishTimeout until (and
+// unless) another event is published.
+type SlowConsumerPolicy int
+
+const (
+	// Block lets the subscriber lag arbitrarily far behind; this is the
+	// default and matches the publisher's original behavior. Since Publish
+	// never waits on subscribers, "blocking" only means the subscriber's own
+	// backlog, not the publisher, grows until it catches up.
+	Block SlowConsumerPolicy = iota
+	// DropOldest discards the oldest entries in the subscriber's backlog to
+	// bring it back within ChannelCapacity.
+	DropOldest
+	// DropNewest discards the event that just pushed the subscriber over
+	// ChannelCapacity, instead of the events already queued.
+	DropNewest
+	// Disconnect unsubscribes the subscriber and invokes its OnDisconnect callback.
+	Disconnect
 )
 
+// event is one published value together with the routing and bookkeeping
+// fields the dispatch loop needs.
+type event[T any] struct {
+	v T
+	// topic and hasTopic record whether this event came from PublishTopic; a
+	// zero-value topic is a valid topic name, so hasTopic distinguishes that
+	// from a plain Publish.
+	topic    string
+	hasTopic bool
+	at       time.Time
+}
+
+type subscriberCloseFunc func()
+
 type subscriber[T any] struct {
+	p       *publisher[T]
 	c       subscriberCloseFunc
-	ev      chan T
 	handler func(v T)
-	mu      sync.Mutex
-	closed  bool
+	// closed is only ever read or written while holding p.mu.
+	closed bool
+
+	// topic is the pattern this subscriber was registered with via
+	// SubscribeTopic, or "" for subscribers added through Subscribe.
+	topic    string
+	hasTopic bool
+
+	// policy and onDisconnect govern what happens when this subscriber falls
+	// behind; see SlowConsumerPolicy.
+	policy       SlowConsumerPolicy
+	onDisconnect func()
+	// maxPending is the backlog size set via ChannelCapacity, or 0 for unbounded.
+	maxPending int
+
+	// cursor is the index of the next event in the publisher's ring this
+	// subscriber hasn't seen yet. It's only ever touched while holding p.mu.
+	cursor int
+	// skip holds ring indices that DropNewest decided this subscriber should
+	// not receive.
+	skip map[int]struct{}
+
+	// errc carries the close reason to Err(), exactly once; errOnce guards it.
+	errc    chan error
+	errOnce sync.Once
 }
 
 // Unsubscribe removes the subscription. No more events will be sent to the handler.
 func (s *subscriber[T]) Unsubscribe() {
 	s.c()
 }
-func (s *subscriber[T]) listen() {
-	for e := range s.ev {
-		s.handler(e)
+
+// Err returns a channel that receives the reason this subscription ended:
+// ErrClosed if the publisher was closed, ErrDisconnected if a
+// SlowConsumerPolicy disconnected it, an error describing a handler panic, or
+// nil if the caller called Unsubscribe. It delivers at most one value and is
+// never closed.
+func (s *subscriber[T]) Err() <-chan error {
+	return s.errc
+}
+
+// run waits for new events via the publisher's condition variable, delivers
+// whatever has accumulated since its last wake-up, then goes back to
+// waiting. It exits as soon as the subscriber is closed (dropping any
+// backlog), once the publisher is closed and there is nothing left to
+// deliver, or once the publisher is force-closed by a
+// CloseContext whose ctx expired, in which case any remaining backlog is
+// dropped instead of delivered.
+func (s *subscriber[T]) run() {
+	p := s.p
+	defer p.wg.Done()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for !s.closed && s.cursor >= p.offset+len(p.ring) {
+			if p.closed {
+				return
+			}
+			p.cond.Wait()
+		}
+		if s.closed {
+			return
+		}
+		if p.forceClosed {
+			return
+		}
+
+		// Advance one event at a time, only after the handler for it has run,
+		// so a slow handler actually shows up as backlog for the
+		// SlowConsumerPolicy checks in applyPolicyLocked.
+		idx := s.cursor
+		e := p.ring[idx-p.offset]
+		_, skipped := s.skip[idx]
+		delete(s.skip, idx)
+		deliver := !skipped && e.hasTopic == s.hasTopic && (!e.hasTopic || matchTopic(s.topic, e.topic))
+		p.mu.Unlock()
+
+		if deliver {
+			if err := s.invoke(e.v); err != nil {
+				p.mu.Lock()
+				p.closeLocked(s, err)
+				p.compactLocked()
+				p.cond.Broadcast()
+				return
+			}
+		}
+
+		p.mu.Lock()
+		// applyPolicyLocked may have advanced s.cursor past idx while the
+		// handler was running (e.g. DropOldest catching up a backlog built up
+		// during delivery); don't step backwards over events it already
+		// skipped past.
+		if s.cursor <= idx {
+			s.cursor = idx + 1
+		}
 	}
 }
 
+// invoke calls the handler, converting a panic into an error instead of
+// crashing the subscriber goroutine.
+func (s *subscriber[T]) invoke(v T) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("pubsub: handler panicked: %v", r)
+		}
+	}()
+	s.handler(v)
+	return nil
+}
+
 type publisher[T any] struct {
-	subs   []*subscriber[T]
-	unsubs chan chan T
 	mu     sync.Mutex
+	cond   *sync.Cond
+	ring   []event[T]
+	offset int // logical index of ring[0] in the overall event stream
+	subs   []*subscriber[T]
 	closed bool
+	// closedc is closed exactly once, when closed transitions to true, so
+	// goroutines outside the subscriber/run machinery (e.g. NewSubscription's
+	// forwarder) can learn the publisher is gone without polling p.mu.
+	closedc chan struct{}
+	// forceClosed is set once CloseContext's ctx fires before every
+	// subscriber has drained its backlog. Subscriber goroutines check it
+	// before each delivery and stop processing further backlog, dropping
+	// whatever is left queued; see run.
+	forceClosed bool
+	// wg tracks running subscriber goroutines so CloseContext can wait for
+	// them to drain their backlog.
+	wg sync.WaitGroup
+
+	// publishTimeout bounds how long an event may sit unread in a
+	// subscriber's backlog before its SlowConsumerPolicy applies.
+	publishTimeout time.Duration
 }
 
-// listening returns whether the publisher is listening for events.
-func (p *publisher[T]) listening() bool { return p.unsubs != nil }
+type publisherOpt[T any] func(*publisher[T])
 
-func (p *publisher[T]) listen() {
-	for c := range p.unsubs {
-		p.mu.Lock()
-		for i, sub := range p.subs {
-			if sub.ev == c {
-				p.subs = append(p.subs[:i], p.subs[i+1:]...)
-				break
-			}
-		}
-		p.mu.Unlock()
+// PublishTimeout sets how long an event may sit unread in a subscriber's
+// backlog before its SlowConsumerPolicy applies, in addition to the
+// ChannelCapacity threshold. The zero value disables this check, leaving
+// ChannelCapacity as the only trigger. Like ChannelCapacity, this is only
+// checked opportunistically on the next Publish/PublishTopic call, not on a
+// background timer: a subscriber that falls behind keeps its stale backlog
+// indefinitely if nothing further is published.
+func PublishTimeout[T any](d time.Duration) publisherOpt[T] {
+	return func(p *publisher[T]) {
+		p.publishTimeout = d
 	}
 }
 
 // New returns a new publisher.
-func New[T any]() *publisher[T] {
-	return &publisher[T]{}
+func New[T any](opts ...publisherOpt[T]) *publisher[T] {
+	p := &publisher[T]{closedc: make(chan struct{})}
+	p.cond = sync.NewCond(&p.mu)
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 type subscribeOpt[T any] func(*subscriber[T])
 
-// ChannelCapacity sets the number of events that can be buffered before blocking.
+// ChannelCapacity sets the number of undelivered events a subscriber may lag
+// behind before its SlowConsumerPolicy applies.
 func ChannelCapacity[T any](n int) subscribeOpt[T] {
 	return func(s *subscriber[T]) {
-		s.ev = make(chan T, n)
+		s.maxPending = n
 	}
 }
 
-// Subscribe returns a subscription that triggers the handler function when a value is published.
-func (p *publisher[T]) Subscribe(handler func(v T), opts ...subscribeOpt[T]) *subscriber[T] {
-	ev := make(chan T)
-	s := subscriber[T]{
-		handler: handler,
-		ev:      ev,
+// SlowConsumer sets the policy applied when the subscriber falls behind by
+// more than ChannelCapacity or PublishTimeout. The default, Block, lets it
+// lag indefinitely.
+func SlowConsumer[T any](policy SlowConsumerPolicy) subscribeOpt[T] {
+	return func(s *subscriber[T]) {
+		s.policy = policy
 	}
-	for _, opt := range opts {
-		opt(&s)
+}
+
+// OnDisconnect registers fn to be called when the subscriber is disconnected
+// because of the Disconnect SlowConsumerPolicy.
+func OnDisconnect[T any](fn func()) subscribeOpt[T] {
+	return func(s *subscriber[T]) {
+		s.onDisconnect = fn
 	}
-	if !p.listening() {
-		// allocate the channel now because we have at least one subscriber
-		p.unsubs = make(chan chan T, 5)
-		go p.listen()
+}
+
+// Subscribe returns a subscription that triggers the handler function when a
+// value is published via Publish. It does not receive PublishTopic events;
+// use SubscribeTopic for those.
+func (p *publisher[T]) Subscribe(handler func(v T), opts ...subscribeOpt[T]) Subscription {
+	return p.subscribe(handler, opts...)
+}
+
+// subscribe does the work behind Subscribe, SubscribeTopic and SubscribeChan,
+// applying opts before the subscriber is registered and its goroutine started.
+func (p *publisher[T]) subscribe(handler func(v T), opts ...subscribeOpt[T]) *subscriber[T] {
+	s := &subscriber[T]{p: p, handler: handler, errc: make(chan error, 1)}
+	for _, opt := range opts {
+		opt(s)
 	}
-	closer := func() {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		if s.closed {
-			return
-		}
+	s.c = func() { p.unsubscribe(s) }
+
+	p.mu.Lock()
+	if p.closed {
 		s.closed = true
-		p.unsubs <- s.ev
+		p.mu.Unlock()
+		s.errc <- ErrClosed
+		return s
+	}
+	s.cursor = p.offset + len(p.ring)
+	p.subs = append(p.subs, s)
+	p.wg.Add(1)
+	p.mu.Unlock()
+
+	go s.run()
+	return s
+}
+
+// SubscribeTopic returns a subscription that triggers the handler when a value
+// is published to a topic matching pattern. A pattern ending in "*" matches
+// any topic that starts with the part before the "*", e.g. "orders.*" matches
+// "orders.created" but not "orders" itself. Otherwise the pattern must match
+// the topic exactly. Unlike a plain Subscribe subscriber, it only receives
+// PublishTopic events; plain Publish events are routed to Subscribe
+// subscribers only.
+func (p *publisher[T]) SubscribeTopic(pattern string, handler func(v T), opts ...subscribeOpt[T]) Subscription {
+	topicOpt := func(s *subscriber[T]) {
+		s.topic = pattern
+		s.hasTopic = true
+	}
+	return p.subscribe(handler, append([]subscribeOpt[T]{topicOpt}, opts...)...)
+}
+
+// SubscribeChan returns a subscription that delivers values to ch instead of
+// invoking a handler, so callers can select over several subscriptions at
+// once. Like a handler, a slow receiver on ch is subject to the subscriber's
+// SlowConsumerPolicy.
+func (p *publisher[T]) SubscribeChan(ch chan<- T, opts ...subscribeOpt[T]) Subscription {
+	return p.subscribe(func(v T) { ch <- v }, opts...)
+}
+
+// matchTopic reports whether topic satisfies pattern, as registered via
+// SubscribeTopic.
+func matchTopic(pattern, topic string) bool {
+	if pattern == topic {
+		return true
 	}
-	s.c = closer
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	return ok && strings.HasPrefix(topic, prefix)
+}
 
-	go s.listen()
+func (p *publisher[T]) unsubscribe(s *subscriber[T]) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.subs = append(p.subs, &s)
-	return &s
+	p.closeLocked(s, nil)
+	p.compactLocked()
+	p.cond.Broadcast()
 }
 
-// Close closes the publisher and all subscriptions. If the publisher is already
-// closed, this method does nothing.
+// closeLocked marks s closed, removes it from p.subs, and delivers err on
+// s.Err() exactly once. It is a no-op if s is already closed. Callers must
+// hold p.mu.
+func (p *publisher[T]) closeLocked(s *subscriber[T], err error) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for i, sub := range p.subs {
+		if sub == s {
+			p.subs = append(p.subs[:i], p.subs[i+1:]...)
+			break
+		}
+	}
+	s.errOnce.Do(func() { s.errc <- err })
+}
+
+// Close closes the publisher and all subscriptions, waiting for events
+// already published to finish being delivered. It is equivalent to
+// CloseContext(context.Background()).
 func (p *publisher[T]) Close() {
+	p.CloseContext(context.Background())
+}
+
+// CloseContext closes the publisher and all subscriptions, then waits for
+// every subscriber to finish delivering events that were already published
+// at the time of the call. If the publisher is already closed, it returns
+// nil immediately. If ctx is done before delivery finishes, CloseContext
+// stops every subscriber from processing any more of its backlog and
+// returns ctx.Err(); a handler already running when ctx fires is left to
+// finish (it cannot be interrupted mid-call), but no further queued events
+// are delivered after that.
+func (p *publisher[T]) CloseContext(ctx context.Context) error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	if p.subs == nil {
-		return
+	if p.closed {
+		p.mu.Unlock()
+		return nil
 	}
+	p.closed = true
+	close(p.closedc)
 	for _, sub := range p.subs {
-		sub.Unsubscribe()
+		sub.errOnce.Do(func() { sub.errc <- ErrClosed })
 	}
+	// p.subs is cleared so SubscriberCount reflects the closed publisher
+	// immediately, but p.ring is left intact: subscriber goroutines hold
+	// their own cursor into it and still need to drain their backlog.
 	p.subs = nil
-	if p.unsubs != nil {
-		close(p.unsubs)
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.forceClosed = true
+		p.cond.Broadcast()
+		p.mu.Unlock()
+		return ctx.Err()
 	}
-	p.closed = true
 }
 
-// Publish publishes a value to all subscribers.
+// Publish publishes a value to every subscriber registered via Subscribe or
+// SubscribeChan; SubscribeTopic subscribers do not receive it. Publish never
+// blocks on subscriber delivery: it appends the event to a shared queue and wakes every
+// subscriber goroutine, each of which drains what it can on its own.
 // If the publisher is closed, ErrClosed is returned. This method is safe for
 // concurrent use.
 func (p *publisher[T]) Publish(v T) error {
+	return p.publish(event[T]{v: v, at: time.Now()})
+}
+
+// PublishTopic publishes a value to every subscriber registered via
+// SubscribeTopic whose pattern matches topic, preserving FIFO delivery within
+// each topic. Plain Subscribe subscribers do not receive these events. If the
+// publisher is closed, ErrClosed is returned.
+func (p *publisher[T]) PublishTopic(topic string, v T) error {
+	return p.publish(event[T]{v: v, topic: topic, hasTopic: true, at: time.Now()})
+}
+
+func (p *publisher[T]) publish(e event[T]) error {
+	p.mu.Lock()
 	if p.closed {
+		p.mu.Unlock()
 		return ErrClosed
 	}
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	for _, sub := range p.subs {
-		sub.ev <- v
+	p.ring = append(p.ring, e)
+	idx := p.offset + len(p.ring) - 1
+	subs := make([]*subscriber[T], len(p.subs))
+	copy(subs, p.subs)
+	for _, s := range subs {
+		p.applyPolicyLocked(s, idx)
 	}
+	p.compactLocked()
+	p.cond.Broadcast()
+	p.mu.Unlock()
 	return nil
 }
 
+// applyPolicyLocked checks whether s has fallen behind by more than its
+// ChannelCapacity, or its oldest pending event is older than PublishTimeout,
+// and if so applies its SlowConsumerPolicy. Callers must hold p.mu.
+func (p *publisher[T]) applyPolicyLocked(s *subscriber[T], newestIdx int) {
+	if s.policy == Block {
+		return
+	}
+	pending := p.offset + len(p.ring) - s.cursor
+	violated := s.maxPending > 0 && pending > s.maxPending
+	if !violated && p.publishTimeout > 0 && pending > 0 {
+		oldest := p.ring[s.cursor-p.offset]
+		violated = time.Since(oldest.at) > p.publishTimeout
+	}
+	if !violated {
+		return
+	}
+
+	switch s.policy {
+	case DropOldest:
+		// Keep ChannelCapacity events when that limit was exceeded; otherwise
+		// (PublishTimeout fired) keep only the newest, so the cursor never
+		// moves backwards.
+		keep := 1
+		if s.maxPending > 0 && pending > s.maxPending {
+			keep = s.maxPending
+		}
+		s.cursor = p.offset + len(p.ring) - keep
+	case DropNewest:
+		if s.skip == nil {
+			s.skip = make(map[int]struct{})
+		}
+		s.skip[newestIdx] = struct{}{}
+	case Disconnect:
+		p.closeLocked(s, ErrDisconnected)
+		if s.onDisconnect != nil {
+			go s.onDisconnect()
+		}
+	}
+}
+
+// compactLocked drops the prefix of the ring that every remaining subscriber
+// has already consumed, so a publisher with no slow subscribers doesn't keep
+// the whole history in memory. Callers must hold p.mu.
+func (p *publisher[T]) compactLocked() {
+	if p.closed {
+		// Subscribers may still be draining their backlog after Close; their
+		// cursors aren't tracked in p.subs anymore, so trimming here could cut
+		// the ring out from under them.
+		return
+	}
+	if len(p.subs) == 0 {
+		p.ring = nil
+		return
+	}
+	min := p.subs[0].cursor
+	for _, s := range p.subs[1:] {
+		if s.cursor < min {
+			min = s.cursor
+		}
+	}
+	if drop := min - p.offset; drop > 0 {
+		p.ring = p.ring[drop:]
+		p.offset = min
+	}
+}
+
 // SubscriberCount returns the number of active subscribers.
 func (p *publisher[T]) SubscriberCount() int {
 	p.mu.Lock()
@@ -0,0 +1,121 @@
+package pubsub
+
+import "sync"
+
+// Subscription represents a live subscription returned by Subscribe or
+// SubscribeTopic. It is modeled on go-ethereum's event.Subscription.
+type Subscription interface {
+	// Unsubscribe cancels the subscription. No more events will be sent to
+	// the handler. Safe to call more than once.
+	Unsubscribe()
+	// Err returns a channel that receives the reason the subscription ended;
+	// see the concrete Err() implementations for what values it carries.
+	Err() <-chan error
+}
+
+// SubscriptionScope bundles subscriptions from possibly many components so
+// they can all be cancelled in one call, typically on shutdown.
+type SubscriptionScope struct {
+	mu     sync.Mutex
+	subs   map[*scopedSubscription]struct{}
+	closed bool
+}
+
+// Track adds sub to the scope and returns a Subscription whose Unsubscribe
+// both cancels sub and removes it from the scope. If the scope has already
+// been closed, sub is unsubscribed immediately.
+func (sc *SubscriptionScope) Track(sub Subscription) Subscription {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		sub.Unsubscribe()
+		return sub
+	}
+	if sc.subs == nil {
+		sc.subs = make(map[*scopedSubscription]struct{})
+	}
+	tracked := &scopedSubscription{Subscription: sub, scope: sc}
+	sc.subs[tracked] = struct{}{}
+	return tracked
+}
+
+// Close unsubscribes every subscription currently tracked by the scope.
+// Subsequent calls to Track unsubscribe their argument immediately instead of
+// tracking it.
+func (sc *SubscriptionScope) Close() {
+	sc.mu.Lock()
+	subs := sc.subs
+	sc.subs = nil
+	sc.closed = true
+	sc.mu.Unlock()
+
+	for sub := range subs {
+		sub.Subscription.Unsubscribe()
+	}
+}
+
+// scopedSubscription wraps a tracked Subscription so that unsubscribing it
+// directly also removes it from its scope.
+type scopedSubscription struct {
+	Subscription
+	scope *SubscriptionScope
+}
+
+func (s *scopedSubscription) Unsubscribe() {
+	s.scope.mu.Lock()
+	delete(s.scope.subs, s)
+	s.scope.mu.Unlock()
+	s.Subscription.Unsubscribe()
+}
+
+// NewSubscription starts producer in a goroutine and returns a Subscription
+// controlling its lifetime, modeled on go-ethereum's event.NewSubscription.
+// producer receives a quit channel, closed when Unsubscribe is called, and an
+// out channel; every value producer sends on out is published to p exactly as
+// if Publish had been called directly, so p's existing subscribers see it.
+// producer must select on quit while sending to out, or it can block forever
+// after Unsubscribe. The error producer returns (nil on a clean exit) is
+// delivered on the subscription's Err(). If p is closed before Unsubscribe is
+// called, the subscription unsubscribes itself so producer doesn't outlive p.
+func (p *publisher[T]) NewSubscription(producer func(quit <-chan struct{}, out chan<- T) error) Subscription {
+	quit := make(chan struct{})
+	out := make(chan T)
+	s := &producerSubscription{quit: quit, errc: make(chan error, 1)}
+
+	go func() {
+		for {
+			select {
+			case v := <-out:
+				p.Publish(v)
+			case <-quit:
+				return
+			case <-p.closedc:
+				s.Unsubscribe()
+				return
+			}
+		}
+	}()
+	go func() {
+		err := producer(quit, out)
+		// errc is buffered with room for exactly one value, so this never
+		// blocks; selecting on quit here too would race with it and could
+		// drop err about as often as not.
+		s.errc <- err
+	}()
+	return s
+}
+
+// producerSubscription is the Subscription returned by NewSubscription.
+type producerSubscription struct {
+	quit chan struct{}
+	errc chan error
+	once sync.Once
+}
+
+func (s *producerSubscription) Unsubscribe() {
+	s.once.Do(func() { close(s.quit) })
+}
+
+func (s *producerSubscription) Err() <-chan error {
+	return s.errc
+}
@@ -1,7 +1,9 @@
 package pubsubdemo
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -112,3 +114,460 @@ func TestPubsub(t *testing.T) {
 		}
 	})
 }
+
+func TestPubsubTopic(t *testing.T) {
+	t.Run("exact topic", func(t *testing.T) {
+		pub := pubsub.New[string]()
+		var got string
+		pub.SubscribeTopic("orders.created", func(v string) { got = v })
+		pub.PublishTopic("orders.created", "order-1")
+		pub.PublishTopic("orders.shipped", "order-2")
+		time.Sleep(10 * time.Millisecond)
+		if got != "order-1" {
+			t.Errorf("got = %q; want %q", got, "order-1")
+		}
+	})
+	t.Run("wildcard topic", func(t *testing.T) {
+		pub := pubsub.New[string]()
+		var got []string
+		var mu sync.Mutex
+		pub.SubscribeTopic("orders.*", func(v string) {
+			mu.Lock()
+			got = append(got, v)
+			mu.Unlock()
+		})
+		pub.PublishTopic("orders.created", "order-1")
+		pub.PublishTopic("orders.shipped", "order-2")
+		pub.PublishTopic("users.created", "user-1")
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) != 2 || got[0] != "order-1" || got[1] != "order-2" {
+			t.Errorf("got = %v; want [order-1 order-2]", got)
+		}
+	})
+	t.Run("unsubscribe topic", func(t *testing.T) {
+		pub := pubsub.New[string]()
+		var got string
+		s := pub.SubscribeTopic("orders.*", func(v string) { got = v })
+		s.Unsubscribe()
+		time.Sleep(10 * time.Millisecond)
+		pub.PublishTopic("orders.created", "order-1")
+		time.Sleep(10 * time.Millisecond)
+		if got != "" {
+			t.Errorf("got = %q; want empty", got)
+		}
+	})
+	t.Run("plain Publish and PublishTopic don't cross over", func(t *testing.T) {
+		pub := pubsub.New[string]()
+		var topicGot, plainGot string
+		pub.SubscribeTopic("orders.*", func(v string) { topicGot = v })
+		pub.Subscribe(func(v string) { plainGot = v })
+		pub.Publish("plain-1")
+		pub.PublishTopic("orders.created", "order-1")
+		time.Sleep(10 * time.Millisecond)
+		if topicGot != "order-1" {
+			t.Errorf("topicGot = %q; want %q (no plain Publish event)", topicGot, "order-1")
+		}
+		if plainGot != "plain-1" {
+			t.Errorf("plainGot = %q; want %q (no PublishTopic event)", plainGot, "plain-1")
+		}
+	})
+}
+
+func TestPublishSlowConsumer(t *testing.T) {
+	t.Run("slow handler doesn't delay fast handler", func(t *testing.T) {
+		pub := pubsub.New[int]()
+		var mu sync.Mutex
+		var fastDone time.Time
+		pub.Subscribe(func(v int) {
+			time.Sleep(10 * time.Millisecond)
+		})
+		pub.Subscribe(func(v int) {
+			mu.Lock()
+			fastDone = time.Now()
+			mu.Unlock()
+		})
+		start := time.Now()
+		pub.Publish(1)
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if fastDone.IsZero() {
+			t.Fatal("fast handler never ran")
+		}
+		if fastDone.Sub(start) > 5*time.Millisecond {
+			t.Errorf("fast handler took %s; want < 5ms", fastDone.Sub(start))
+		}
+	})
+	t.Run("drop newest on full channel", func(t *testing.T) {
+		// Capacity 1 means only the event already being handled may be
+		// in flight; everything published while the handler is still
+		// busy with it gets dropped.
+		pub := pubsub.New[int]()
+		var got []int
+		var mu sync.Mutex
+		pub.Subscribe(func(v int) {
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			got = append(got, v)
+			mu.Unlock()
+		}, pubsub.ChannelCapacity[int](1), pubsub.SlowConsumer[int](pubsub.DropNewest))
+		pub.Publish(1)
+		pub.Publish(2)
+		pub.Publish(3)
+		time.Sleep(30 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) != 1 || got[0] != 1 {
+			t.Errorf("got = %v; want [1]", got)
+		}
+	})
+	t.Run("drop oldest on full channel", func(t *testing.T) {
+		// The event already in flight must survive even though the handler
+		// is busy with it when later publishes push the subscriber over
+		// capacity; only the newest of the backlogged events should too.
+		pub := pubsub.New[int]()
+		var got []int
+		var mu sync.Mutex
+		pub.Subscribe(func(v int) {
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			got = append(got, v)
+			mu.Unlock()
+		}, pubsub.ChannelCapacity[int](1), pubsub.SlowConsumer[int](pubsub.DropOldest))
+		pub.Publish(1)
+		time.Sleep(5 * time.Millisecond)
+		pub.Publish(2)
+		pub.Publish(3)
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+			t.Errorf("got = %v; want [1 3]", got)
+		}
+	})
+	t.Run("disconnect on full channel", func(t *testing.T) {
+		pub := pubsub.New[int]()
+		var mu sync.Mutex
+		var disconnected bool
+		pub.Subscribe(func(v int) {
+			time.Sleep(20 * time.Millisecond)
+		}, pubsub.ChannelCapacity[int](1), pubsub.SlowConsumer[int](pubsub.Disconnect),
+			pubsub.OnDisconnect[int](func() {
+				mu.Lock()
+				disconnected = true
+				mu.Unlock()
+			}))
+		pub.Publish(1)
+		pub.Publish(2)
+		time.Sleep(30 * time.Millisecond)
+		mu.Lock()
+		if !disconnected {
+			t.Errorf("disconnected = false; want true")
+		}
+		mu.Unlock()
+		if n := pub.SubscriberCount(); n != 0 {
+			t.Errorf("n = %d; want 0", n)
+		}
+	})
+	t.Run("PublishTimeout never fires without a follow-up publish", func(t *testing.T) {
+		// PublishTimeout is only checked opportunistically from inside
+		// Publish/PublishTopic; with no second publish, nothing ever
+		// re-checks a subscriber that fell behind, no matter how long it sits.
+		pub := pubsub.New[int](pubsub.PublishTimeout[int](10 * time.Millisecond))
+		var mu sync.Mutex
+		var disconnected bool
+		pub.Subscribe(func(v int) {
+			time.Sleep(50 * time.Millisecond)
+		}, pubsub.SlowConsumer[int](pubsub.Disconnect), pubsub.OnDisconnect[int](func() {
+			mu.Lock()
+			disconnected = true
+			mu.Unlock()
+		}))
+		pub.Publish(1)
+		time.Sleep(100 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if disconnected {
+			t.Errorf("disconnected = true; want false")
+		}
+	})
+	t.Run("PublishTimeout fires on the next publish once backlog goes stale", func(t *testing.T) {
+		pub := pubsub.New[int](pubsub.PublishTimeout[int](10 * time.Millisecond))
+		var mu sync.Mutex
+		var disconnected bool
+		pub.Subscribe(func(v int) {
+			time.Sleep(50 * time.Millisecond)
+		}, pubsub.SlowConsumer[int](pubsub.Disconnect), pubsub.OnDisconnect[int](func() {
+			mu.Lock()
+			disconnected = true
+			mu.Unlock()
+		}))
+		pub.Publish(1)
+		time.Sleep(20 * time.Millisecond)
+		pub.Publish(2)
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if !disconnected {
+			t.Errorf("disconnected = false; want true")
+		}
+	})
+}
+
+func TestSubscriptionErr(t *testing.T) {
+	t.Run("nil on user unsubscribe", func(t *testing.T) {
+		pub := pubsub.New[int]()
+		s := pub.Subscribe(func(v int) {})
+		s.Unsubscribe()
+		select {
+		case err := <-s.Err():
+			if err != nil {
+				t.Errorf("err = %v; want nil", err)
+			}
+		case <-time.After(50 * time.Millisecond):
+			t.Fatal("timed out waiting for Err()")
+		}
+	})
+	t.Run("ErrClosed when publisher closes", func(t *testing.T) {
+		pub := pubsub.New[int]()
+		s := pub.Subscribe(func(v int) {})
+		pub.Close()
+		select {
+		case err := <-s.Err():
+			if !errors.Is(err, pubsub.ErrClosed) {
+				t.Errorf("err = %v; want %v", err, pubsub.ErrClosed)
+			}
+		case <-time.After(50 * time.Millisecond):
+			t.Fatal("timed out waiting for Err()")
+		}
+	})
+	t.Run("ErrDisconnected on slow consumer disconnect", func(t *testing.T) {
+		pub := pubsub.New[int]()
+		s := pub.Subscribe(func(v int) {
+			time.Sleep(20 * time.Millisecond)
+		}, pubsub.ChannelCapacity[int](1), pubsub.SlowConsumer[int](pubsub.Disconnect))
+		pub.Publish(1)
+		pub.Publish(2)
+		select {
+		case err := <-s.Err():
+			if !errors.Is(err, pubsub.ErrDisconnected) {
+				t.Errorf("err = %v; want %v", err, pubsub.ErrDisconnected)
+			}
+		case <-time.After(50 * time.Millisecond):
+			t.Fatal("timed out waiting for Err()")
+		}
+	})
+	t.Run("handler panic reports an error", func(t *testing.T) {
+		pub := pubsub.New[int]()
+		s := pub.Subscribe(func(v int) {
+			panic("boom")
+		})
+		pub.Publish(1)
+		select {
+		case err := <-s.Err():
+			if err == nil {
+				t.Errorf("err = nil; want non-nil")
+			}
+		case <-time.After(50 * time.Millisecond):
+			t.Fatal("timed out waiting for Err()")
+		}
+	})
+}
+
+func TestSubscriptionScope(t *testing.T) {
+	pub := pubsub.New[int]()
+	var scope pubsub.SubscriptionScope
+	var got1, got2 int
+	scope.Track(pub.Subscribe(func(v int) { got1 = v }))
+	scope.Track(pub.Subscribe(func(v int) { got2 = v }))
+	pub.Publish(1)
+	time.Sleep(10 * time.Millisecond)
+	if got1 != 1 || got2 != 1 {
+		t.Errorf("got1, got2 = %d, %d; want 1, 1", got1, got2)
+	}
+	scope.Close()
+	time.Sleep(10 * time.Millisecond)
+	pub.Publish(2)
+	time.Sleep(10 * time.Millisecond)
+	if got1 != 1 || got2 != 1 {
+		t.Errorf("got1, got2 = %d, %d; want 1, 1 (unchanged after scope close)", got1, got2)
+	}
+	if n := pub.SubscriberCount(); n != 0 {
+		t.Errorf("n = %d; want 0", n)
+	}
+}
+
+func TestSubscribeChan(t *testing.T) {
+	pub := pubsub.New[int]()
+	ch := make(chan int, 1)
+	sub := pub.SubscribeChan(ch)
+	pub.Publish(1)
+	select {
+	case v := <-ch:
+		if v != 1 {
+			t.Errorf("v = %d; want 1", v)
+		}
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("timed out waiting for value")
+	}
+	sub.Unsubscribe()
+}
+
+func TestNewSubscription(t *testing.T) {
+	pub := pubsub.New[int]()
+	var got []int
+	var mu sync.Mutex
+	pub.Subscribe(func(v int) {
+		mu.Lock()
+		got = append(got, v)
+		mu.Unlock()
+	})
+
+	sub := pub.NewSubscription(func(quit <-chan struct{}, out chan<- int) error {
+		for i := 1; i <= 3; i++ {
+			select {
+			case out <- i:
+			case <-quit:
+				return nil
+			}
+		}
+		return nil
+	})
+
+	select {
+	case err := <-sub.Err():
+		if err != nil {
+			t.Errorf("err = %v; want nil", err)
+		}
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("timed out waiting for producer to finish")
+	}
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("got = %v; want [1 2 3]", got)
+	}
+}
+
+// TestNewSubscriptionUnsubscribeErrDelivery guards against a race where
+// Unsubscribe racing with the producer's return could drop its error instead
+// of delivering it on Err(); loop many times since the race only showed up
+// probabilistically.
+func TestNewSubscriptionUnsubscribeErrDelivery(t *testing.T) {
+	pub := pubsub.New[int]()
+	for i := 0; i < 50; i++ {
+		sub := pub.NewSubscription(func(quit <-chan struct{}, out chan<- int) error {
+			<-quit
+			return nil
+		})
+		sub.Unsubscribe()
+		select {
+		case err := <-sub.Err():
+			if err != nil {
+				t.Errorf("err = %v; want nil", err)
+			}
+		case <-time.After(50 * time.Millisecond):
+			t.Fatal("timed out waiting for Err() after Unsubscribe")
+		}
+	}
+}
+
+func TestNewSubscriptionStopsWhenPublisherCloses(t *testing.T) {
+	pub := pubsub.New[int]()
+	started := make(chan struct{})
+	sub := pub.NewSubscription(func(quit <-chan struct{}, out chan<- int) error {
+		close(started)
+		<-quit
+		return nil
+	})
+	<-started
+	pub.Close()
+	select {
+	case err := <-sub.Err():
+		if err != nil {
+			t.Errorf("err = %v; want nil", err)
+		}
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("timed out waiting for subscription to stop after publisher closed")
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	t.Run("waits for in-flight backlog to drain", func(t *testing.T) {
+		pub := pubsub.New[int]()
+		var mu sync.Mutex
+		var got []int
+		pub.Subscribe(func(v int) {
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			got = append(got, v)
+			mu.Unlock()
+		})
+		pub.Publish(1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := pub.CloseContext(ctx); err != nil {
+			t.Errorf("err = %v; want nil", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) != 1 || got[0] != 1 {
+			t.Errorf("got = %v; want [1]", got)
+		}
+	})
+	t.Run("drops queued backlog when deadline fires first", func(t *testing.T) {
+		pub := pubsub.New[int]()
+		var mu sync.Mutex
+		var got []int
+		pub.Subscribe(func(v int) {
+			time.Sleep(30 * time.Millisecond)
+			mu.Lock()
+			got = append(got, v)
+			mu.Unlock()
+		})
+		// The first event starts its 30ms handler immediately and is already
+		// in flight by the time the context below expires; 2-5 are still
+		// queued and should never be delivered.
+		for v := 1; v <= 5; v++ {
+			pub.Publish(v)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		if err := pub.CloseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("err = %v; want %v", err, context.DeadlineExceeded)
+		}
+		// Long enough that, were the backlog not dropped, all 5 events would
+		// have been delivered by now (5 * 30ms).
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) != 1 || got[0] != 1 {
+			t.Errorf("got = %v; want [1]", got)
+		}
+	})
+}
+
+// BenchmarkPublishManySubscribers measures Publish throughput as the number
+// of subscribers grows. Publish only appends to the shared ring and
+// broadcasts under the ring-buffer design (see the chunk0-3 commit), instead
+// of sending into each subscriber's own channel, so it should scale far
+// better with subscriber count than the original per-subscriber-channel
+// implementation at fdda6f5 (run `git checkout fdda6f5 -- pubsub pubsub_test.go`
+// to reproduce the old numbers for comparison).
+func BenchmarkPublishManySubscribers(b *testing.B) {
+	for _, n := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("%d_subscribers", n), func(b *testing.B) {
+			pub := pubsub.New[int]()
+			for i := 0; i < n; i++ {
+				pub.Subscribe(func(v int) {})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pub.Publish(i)
+			}
+		})
+	}
+}